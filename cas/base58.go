@@ -0,0 +1,35 @@
+package cas
+
+import "math/big"
+
+// base58Alphabet is the Bitcoin base58 alphabet: digits 0, and letters O,
+// I, l are dropped to avoid visual ambiguity.
+const base58Alphabet = "123456789ABCDEFGHJKLMNPQRSTUVWXYZabcdefghijkmnopqrstuvwxyz"
+
+func base58Encode(input []byte) string {
+	zeroCount := 0
+	for zeroCount < len(input) && input[zeroCount] == 0 {
+		zeroCount++
+	}
+
+	x := new(big.Int).SetBytes(input)
+	base := big.NewInt(int64(len(base58Alphabet)))
+	mod := new(big.Int)
+
+	var digits []byte
+	for x.Sign() > 0 {
+		x.DivMod(x, base, mod)
+		digits = append(digits, base58Alphabet[mod.Int64()])
+	}
+
+	out := make([]byte, 0, zeroCount+len(digits))
+	for i := 0; i < zeroCount; i++ {
+		out = append(out, base58Alphabet[0])
+	}
+
+	for i := len(digits) - 1; i >= 0; i-- {
+		out = append(out, digits[i])
+	}
+
+	return string(out)
+}
@@ -0,0 +1,101 @@
+// Package cas derives content-addressable record ids: a record's JSON is
+// canonicalized, hashed with SHA-256, wrapped in a multihash envelope and
+// base58-encoded, so the id is tamper-evident and reproducible from the
+// record's content alone.
+package cas
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Multihash function code and digest length for SHA2-256, per the
+// multihash spec: https://github.com/multiformats/multihash
+const (
+	sha2256Code byte = 0x12
+	sha2256Len  byte = 0x20
+)
+
+// ErrNoMatch is returned by ResolvePrefix when no id matches.
+var ErrNoMatch = errors.New("no record id matches prefix")
+
+// ErrAmbiguousPrefix is returned by ResolvePrefix when more than one id
+// matches.
+var ErrAmbiguousPrefix = errors.New("prefix is ambiguous")
+
+// Canonicalize produces a deterministic encoding of a record's JSON for
+// hashing: object keys sorted alphabetically, no whitespace, and the "id"
+// field dropped since it is what ID is about to derive.
+func Canonicalize(recordJSON []byte) ([]byte, error) {
+	var fields map[string]interface{}
+
+	if err := json.Unmarshal(recordJSON, &fields); err != nil {
+		return nil, err
+	}
+
+	delete(fields, "id")
+
+	// encoding/json marshals map[string]interface{} with keys sorted
+	// alphabetically and no extraneous whitespace, which is exactly the
+	// canonical form we want.
+	return json.Marshal(fields)
+}
+
+// ID derives the content-addressable id for recordJSON.
+func ID(recordJSON []byte) (string, error) {
+	canon, err := Canonicalize(recordJSON)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(canon)
+
+	mh := make([]byte, 0, 2+len(sum))
+	mh = append(mh, sha2256Code, sha2256Len)
+	mh = append(mh, sum[:]...)
+
+	return base58Encode(mh), nil
+}
+
+// Verify reports whether id is the correct content-addressable id for
+// recordJSON.
+func Verify(recordJSON []byte, id string) (bool, error) {
+	want, err := ID(recordJSON)
+	if err != nil {
+		return false, err
+	}
+
+	return want == id, nil
+}
+
+// ResolvePrefix finds the id in ids that equals prefix exactly, or is the
+// unique id starting with prefix. It returns ErrNoMatch or
+// ErrAmbiguousPrefix (wrapped, so errors.Is works) otherwise.
+func ResolvePrefix(ids []string, prefix string) (string, error) {
+	if prefix == "" {
+		return "", ErrNoMatch
+	}
+
+	var matches []string
+
+	for _, id := range ids {
+		if id == prefix {
+			return id, nil
+		}
+		if strings.HasPrefix(id, prefix) {
+			matches = append(matches, id)
+		}
+	}
+
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("%w: %q", ErrNoMatch, prefix)
+	case 1:
+		return matches[0], nil
+	default:
+		return "", fmt.Errorf("%w: %q matches %d ids", ErrAmbiguousPrefix, prefix, len(matches))
+	}
+}
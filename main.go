@@ -1,15 +1,19 @@
 package main
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"os"
+	"slices"
+	"strings"
 
-	"golang.org/x/exp/slices"
+	"github.com/Alxblsk/golang-united-school-homework-8/cas"
+	"github.com/Alxblsk/golang-united-school-homework-8/output"
+	"github.com/Alxblsk/golang-united-school-homework-8/store"
 )
 
 type Arguments map[string]string
@@ -23,25 +27,38 @@ var pOperation = "operation"
 var pFileName = "fileName"
 var pItem = "item"
 var pId = "id"
+var pOutput = "output"
+var pIdScheme = "idScheme"
+var pVerify = "verify"
+var pFormat = "format"
 
 var opAdd = "add"
 var opList = "list"
 var opFind = "findById"
 var opRemove = "remove"
+var opMigrate = "migrate"
 
-var operationsIndex = []string{opAdd, opList, opFind, opRemove}
+var idSchemeCAS = "cas"
+
+var operationsIndex = []string{opAdd, opList, opFind, opRemove, opMigrate}
 
 type opRequirements []string
 
 var requiredFlag = pOperation
 var additionalRequiredFlags = map[string]opRequirements{
-	opList:   []string{pFileName},
-	opAdd:    []string{pFileName, pItem},
-	opFind:   []string{pFileName, pId},
-	opRemove: []string{pFileName, pId},
+	opList:    []string{pFileName},
+	opAdd:     []string{pFileName, pItem},
+	opFind:    []string{pFileName, pId},
+	opRemove:  []string{pFileName, pId},
+	opMigrate: []string{pFileName, pFormat},
 }
 
-func Perform(args Arguments, writer io.Writer) error {
+// Perform runs the requested operation and materializes its result. With
+// no -output specs given, buf is written to writer as before (the CLI's
+// default is a single "-output stdout" equivalent). Any -output specs
+// given instead replace that default write, each materializing the
+// result at its own destination via the output package's sink registry.
+func Perform(args Arguments, outputs []string, writer io.Writer) error {
 	err0 := validateConsequently(args, requiredFlag, additionalRequiredFlags)
 
 	if err0 != nil {
@@ -60,14 +77,108 @@ func Perform(args Arguments, writer io.Writer) error {
 		buf, err = find(args)
 	case opRemove:
 		buf, err = remove(args)
+	case opMigrate:
+		buf, err = migrate(args)
 	}
 
 	if err != nil {
-		fmt.Println("err?", err, args)
 		return err
 	}
 
-	writer.Write(buf)
+	if len(outputs) == 0 {
+		writer.Write(buf)
+	}
+
+	if args[pVerify] == "true" {
+		reportVerifyMismatches(args, buf)
+	}
+
+	return writeOutputs(args, buf, outputs)
+}
+
+// reportVerifyMismatches recomputes the content-addressable multihash for
+// every record a list/find returned and warns on stderr about any whose
+// stored id no longer matches, giving a tamper-evident check on demand.
+func reportVerifyMismatches(args Arguments, buf []byte) {
+	var records []Record
+
+	switch args[pOperation] {
+	case opList:
+		if len(buf) == 0 {
+			return
+		}
+		if err := json.Unmarshal(buf, &records); err != nil {
+			return
+		}
+	case opFind:
+		if len(buf) == 0 {
+			return
+		}
+		var rec Record
+		if err := json.Unmarshal(buf, &rec); err != nil {
+			return
+		}
+		records = []Record{rec}
+	default:
+		return
+	}
+
+	var mismatched []string
+	for _, rec := range records {
+		raw, err := json.Marshal(rec)
+		if err != nil {
+			continue
+		}
+		if ok, err := cas.Verify(raw, rec.Id); err != nil || !ok {
+			mismatched = append(mismatched, rec.Id)
+		}
+	}
+
+	if len(mismatched) > 0 {
+		fmt.Fprintln(os.Stderr, "WARNING: multihash verification failed for ids:", strings.Join(mismatched, ", "))
+	}
+}
+
+// writeOutputs parses each -output spec and runs it through the matching
+// sink. Operations that return record sets (list/find) have their
+// individual records split out so sinks like tar/dir can use them.
+func writeOutputs(args Arguments, buf []byte, outputs []string) error {
+	if len(outputs) == 0 {
+		return nil
+	}
+
+	res := output.Result{Raw: buf}
+
+	switch args[pOperation] {
+	case opList:
+		var records []json.RawMessage
+		if len(buf) != 0 {
+			if err := json.Unmarshal(buf, &records); err != nil {
+				return err
+			}
+		}
+		res.Records = records
+	case opFind:
+		if len(buf) != 0 {
+			res.Records = []json.RawMessage{json.RawMessage(buf)}
+		}
+	}
+
+	for _, raw := range outputs {
+		spec, err := output.ParseSpec(raw)
+		if err != nil {
+			return err
+		}
+
+		sink, err := output.New(spec)
+		if err != nil {
+			return err
+		}
+
+		if err := sink.Write(res); err != nil {
+			return err
+		}
+	}
 
 	return nil
 }
@@ -108,155 +219,165 @@ func Perform(args Arguments, writer io.Writer) error {
 // 	return buf, nil
 // }
 
-func read(args Arguments) ([]byte, error) {
-	var r, err = os.OpenFile(args[pFileName], os.O_RDONLY|os.O_CREATE, 0444)
+// newStoreDB opens args[pFileName] through the store package, honoring
+// -format when a brand-new/empty file needs one.
+func newStoreDB(args Arguments) (*store.DB, error) {
+	var format store.Format
+	if args[pFormat] != "" {
+		format = store.Format(args[pFormat])
+	}
 
-	defer r.Close()
+	return store.NewDB(args[pFileName], format)
+}
 
+func read(args Arguments) ([]byte, error) {
+	db, err := newStoreDB(args)
 	if err != nil {
 		return nil, err
 	}
+	defer db.Close()
 
-	buf, err := ioutil.ReadAll(r)
-
-	if err != nil {
+	var buf bytes.Buffer
+	if err := db.Perform(store.Args{"operation": store.OpList}, &buf); err != nil {
 		return nil, err
 	}
 
-	// m := []Record{}
-
-	// err2 := json.Unmarshal(buf, &m)
-
-	// if err2 != nil {
-	// 	return nil, err2
-	// }
-
-	return buf, nil
+	return buf.Bytes(), nil
 }
 
+// add opens the store for a single call, applies the mutation through it
+// (so it shares the DB's advisory lock and background writer with any
+// other concurrent caller of the same file), and closes it again.
 func add(args Arguments) ([]byte, error) {
-	var rw, err = os.OpenFile(args[pFileName], os.O_RDWR|os.O_CREATE, 0644)
-
-	defer rw.Close()
+	n := Record{}
+	item := args[pItem]
 
-	if err != nil {
+	if err := json.Unmarshal([]byte(item), &n); err != nil {
 		return nil, err
 	}
 
-	buf, err := ioutil.ReadAll(rw)
+	if args[pIdScheme] == idSchemeCAS {
+		id, err := cas.ID([]byte(item))
+		if err != nil {
+			return nil, err
+		}
 
-	m := []Record{}
+		n.Id = id
 
-	if len(buf) != 0 {
-		err2 := json.Unmarshal(buf, &m)
-		if err2 != nil {
-			return nil, err2
+		marshalled, err := json.Marshal(n)
+		if err != nil {
+			return nil, err
 		}
+		item = string(marshalled)
 	}
 
-	n := Record{}
-	item := args[pItem]
-
-	err3 := json.Unmarshal([]byte(item), &n)
-
-	if err3 != nil {
-		return nil, err3
+	db, err := newStoreDB(args)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	for _, rec := range m {
-		if rec.Id == n.Id {
-			return []byte("Item with id " + rec.Id + " already exists"), nil
-		}
+	var buf bytes.Buffer
+	if err := db.Perform(store.Args{"operation": store.OpAdd, "item": item}, &buf); err != nil {
+		return nil, err
 	}
 
-	m = append(m, n)
-	marshalled, errLast := json.Marshal(m)
-
-	if errLast == nil {
-		if err := ioutil.WriteFile(args[pFileName], marshalled, 0660); err != nil {
-			return nil, err
-		}
+	out := buf.Bytes()
+	if args[pIdScheme] == idSchemeCAS && string(out) == "Item with id "+n.Id+" already exists" {
+		return []byte("identical record already stored"), nil
 	}
 
-	return marshalled, errLast
+	return out, nil
 }
 
 func find(args Arguments) ([]byte, error) {
-	var rw, err = os.OpenFile(args[pFileName], os.O_RDONLY|os.O_CREATE, 0644)
-
-	defer rw.Close()
-
+	db, err := newStoreDB(args)
 	if err != nil {
 		return nil, err
 	}
+	defer db.Close()
 
-	buf, err := ioutil.ReadAll(rw)
+	var buf bytes.Buffer
+	if err := db.Perform(store.Args{"operation": store.OpFind, "id": args[pId]}, &buf); err != nil {
+		return nil, err
+	}
 
-	m := []Record{}
+	if buf.Len() > 0 {
+		return buf.Bytes(), nil
+	}
 
-	if len(buf) == 0 {
-		return nil, errors.New("No data to search for a record")
+	if args[pIdScheme] != idSchemeCAS {
+		return nil, nil
 	}
 
-	err2 := json.Unmarshal(buf, &m)
-	if err2 != nil {
-		return nil, err2
+	// No exact id match: fall back to resolving args[pId] as a short
+	// prefix of a base58 multihash id, a convenience only "cas" ids
+	// support. No match or an ambiguous prefix are both reported the
+	// same way an exact miss is: no output, no error.
+	var listBuf bytes.Buffer
+	if err := db.Perform(store.Args{"operation": store.OpList}, &listBuf); err != nil {
+		return nil, err
 	}
 
-	for _, rec := range m {
-		if rec.Id == args[pId] {
-			marshalled, err := json.Marshal(rec)
-			return marshalled, err
+	var records []Record
+	if listBuf.Len() != 0 {
+		if err := json.Unmarshal(listBuf.Bytes(), &records); err != nil {
+			return nil, err
 		}
 	}
 
-	return nil, nil
-}
-
-func remove(args Arguments) ([]byte, error) {
-	var rw, err = os.OpenFile(args[pFileName], os.O_RDONLY|os.O_CREATE, 0644)
+	ids := make([]string, len(records))
+	for i, rec := range records {
+		ids[i] = rec.Id
+	}
 
-	defer rw.Close()
+	resolved, errPrefix := cas.ResolvePrefix(ids, args[pId])
+	if errPrefix != nil {
+		if errors.Is(errPrefix, cas.ErrNoMatch) || errors.Is(errPrefix, cas.ErrAmbiguousPrefix) {
+			return nil, nil
+		}
+		return nil, errPrefix
+	}
 
-	if err != nil {
+	var resolvedBuf bytes.Buffer
+	if err := db.Perform(store.Args{"operation": store.OpFind, "id": resolved}, &resolvedBuf); err != nil {
 		return nil, err
 	}
 
-	buf, err := ioutil.ReadAll(rw)
-
-	m := []Record{}
+	return resolvedBuf.Bytes(), nil
+}
 
-	if len(buf) == 0 {
-		return nil, errors.New("No data to search for a record")
+func remove(args Arguments) ([]byte, error) {
+	db, err := newStoreDB(args)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	err2 := json.Unmarshal(buf, &m)
-	if err2 != nil {
-		return nil, err2
+	var buf bytes.Buffer
+	if err := db.Perform(store.Args{"operation": store.OpRemove, "id": args[pId]}, &buf); err != nil {
+		return nil, err
 	}
 
-	var foundId string
-
-	for ind, rec := range m {
-		if rec.Id == args[pId] {
-			foundId = rec.Id
-			m = append(m[:ind], m[ind+1:]...)
-		}
-	}
+	return buf.Bytes(), nil
+}
 
-	if foundId == "" {
-		return []byte("Item with id " + args[pId] + " not found"), nil
+// migrate converts the DB file between the ndjson and jsonarray formats,
+// with -format naming the target format.
+func migrate(args Arguments) ([]byte, error) {
+	db, err := newStoreDB(args)
+	if err != nil {
+		return nil, err
 	}
+	defer db.Close()
 
-	marshalled, errLast := json.Marshal(m)
-
-	if errLast == nil {
-		if err := ioutil.WriteFile(args[pFileName], marshalled, 0660); err != nil {
-			return nil, err
-		}
+	var buf bytes.Buffer
+	storeArgs := store.Args{"operation": store.OpMigrate, "format": args[pFormat]}
+	if err := db.Perform(storeArgs, &buf); err != nil {
+		return nil, err
 	}
 
-	return marshalled, errLast
+	return buf.Bytes(), nil
 }
 
 func validateConsequently(args Arguments, reqFlag string, params map[string]opRequirements) error {
@@ -301,33 +422,55 @@ func validateParamEntered(args Arguments, params []string) error {
 	return nil
 }
 
-func parseArgs() Arguments {
-	var flagNames = [4]string{pOperation, pFileName, pItem, pId}
-	var flagValues = [4]string{"", "", "", ""}
+// outputFlag collects one or more repeated -output flag values, e.g.
+// "-output type=file,dest=a.json -output -".
+type outputFlag []string
+
+func (o *outputFlag) String() string {
+	return strings.Join(*o, ",")
+}
 
-	flag.StringVar(&flagValues[0], flagNames[0], "", "Possible values are 'list', 'add', 'findById', 'remove'")
+func (o *outputFlag) Set(value string) error {
+	*o = append(*o, value)
+	return nil
+}
+
+func parseArgs() (Arguments, []string) {
+	var flagNames = [6]string{pOperation, pFileName, pItem, pId, pIdScheme, pFormat}
+	var flagValues = [6]string{"", "", "", "", "", ""}
+	var outputs outputFlag
+	var verify bool
+
+	flag.StringVar(&flagValues[0], flagNames[0], "", "Possible values are 'list', 'add', 'findById', 'remove', 'migrate'")
 	flag.StringVar(&flagValues[1], flagNames[1], "", "Path to a DB file")
 	flag.StringVar(&flagValues[2], flagNames[2], "", "Item to add")
 	flag.StringVar(&flagValues[3], flagNames[3], "", "ID to search for")
+	flag.StringVar(&flagValues[4], flagNames[4], "", "Id scheme; 'cas' derives the id from the record's content on 'add', and also resolves a unique id prefix on 'findById'")
+	flag.StringVar(&flagValues[5], flagNames[5], "", "On-disk format 'ndjson' or 'jsonarray'; forces the format for a new file, or names the target format for 'migrate'")
+	flag.Var(&outputs, pOutput, "Output spec 'type=...,dest=...' (repeatable); bare value defaults to type=file, '-' to type=tar,dest=-")
+	flag.BoolVar(&verify, pVerify, false, "Recompute and verify each record's content-addressable id on 'list'/'findById'")
 
 	flag.Parse()
 
 	var args = make(Arguments)
 
 	for indx, flagName := range flagNames {
-		fmt.Println(indx, flagName, flagValues[indx])
 		if len(flagValues[indx]) > 0 {
 			args[flagName] = flagValues[indx]
 		}
 	}
 
-	fmt.Println("!!args", args)
+	if verify {
+		args[pVerify] = "true"
+	}
 
-	return args
+	return args, outputs
 }
 
 func main() {
-	err := Perform(parseArgs(), os.Stdout)
+	args, outputs := parseArgs()
+
+	err := Perform(args, outputs, os.Stdout)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,46 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func init() {
+	Register(TypeDir, newDirSink)
+}
+
+type dirSink struct {
+	dest string
+}
+
+func newDirSink(dest string) (Sink, error) {
+	return dirSink{dest: dest}, nil
+}
+
+// Write materializes one file per record into the destination directory,
+// named after the record's id. It only makes sense for list/find results.
+func (s dirSink) Write(res Result) error {
+	if len(res.Records) == 0 {
+		return errors.New("output type=dir requires a list/find result with records")
+	}
+
+	if err := os.MkdirAll(s.dest, 0755); err != nil {
+		return err
+	}
+
+	for i, raw := range res.Records {
+		name := recordID(raw)
+		if name == "" {
+			name = fmt.Sprintf("%d", i)
+		}
+
+		if err := ioutil.WriteFile(filepath.Join(s.dest, name+".json"), raw, 0660); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
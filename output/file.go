@@ -0,0 +1,19 @@
+package output
+
+import "io/ioutil"
+
+func init() {
+	Register(TypeFile, newFileSink)
+}
+
+type fileSink struct {
+	dest string
+}
+
+func newFileSink(dest string) (Sink, error) {
+	return fileSink{dest: dest}, nil
+}
+
+func (s fileSink) Write(res Result) error {
+	return ioutil.WriteFile(s.dest, res.Raw, 0660)
+}
@@ -0,0 +1,53 @@
+package output
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// Result is what a Perform operation produces: the raw JSON exactly as it
+// would have been written to the legacy io.Writer, plus the individual
+// records it contains, when the operation is record-shaped (list/find).
+// Records is nil for operations such as add/remove, which return the added
+// record or a status message rather than a record set.
+type Result struct {
+	Raw     []byte
+	Records []json.RawMessage
+}
+
+// Sink materializes a Result at the destination described by a Spec.
+type Sink interface {
+	Write(res Result) error
+}
+
+// Factory builds a Sink for a given dest string.
+type Factory func(dest string) (Sink, error)
+
+var registry = map[string]Factory{}
+
+// Register adds a Sink factory under typeName, for use by New.
+func Register(typeName string, f Factory) {
+	registry[typeName] = f
+}
+
+// New builds the Sink described by spec, looking it up in the registry.
+func New(spec Spec) (Sink, error) {
+	f, ok := registry[spec.Type]
+	if !ok {
+		return nil, fmt.Errorf("output type %q not supported", spec.Type)
+	}
+
+	return f(spec.Dest)
+}
+
+func recordID(raw json.RawMessage) string {
+	var rec struct {
+		Id string `json:"id"`
+	}
+
+	if err := json.Unmarshal(raw, &rec); err != nil || rec.Id == "" {
+		return ""
+	}
+
+	return rec.Id
+}
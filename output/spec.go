@@ -0,0 +1,65 @@
+// Package output implements pluggable destinations for the CLI's results,
+// parsed from "-output type=...,dest=..." flags the same way Docker
+// BuildKit's parseOutputs parses comma-separated key=value pairs.
+package output
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Supported sink type names.
+const (
+	TypeStdout = "stdout"
+	TypeFile   = "file"
+	TypeTar    = "tar"
+	TypeDir    = "dir"
+)
+
+// Spec is one parsed "-output" value.
+type Spec struct {
+	Type string
+	Dest string
+}
+
+// ParseSpec parses a single -output flag value. A bare path with no
+// "type=" prefix defaults to type=file,dest=<path>, and "-" defaults to
+// type=tar,dest=-.
+func ParseSpec(raw string) (Spec, error) {
+	if raw == "" {
+		return Spec{}, errors.New("-output flag has to be specified")
+	}
+
+	if raw == "-" {
+		return Spec{Type: TypeTar, Dest: "-"}, nil
+	}
+
+	if !strings.Contains(raw, "=") {
+		return Spec{Type: TypeFile, Dest: raw}, nil
+	}
+
+	spec := Spec{Type: TypeStdout}
+
+	for _, pair := range strings.Split(raw, ",") {
+		kv := strings.SplitN(pair, "=", 2)
+		if len(kv) != 2 {
+			return Spec{}, fmt.Errorf("malformed -output key=value pair %q", pair)
+		}
+
+		switch kv[0] {
+		case "type":
+			spec.Type = kv[1]
+		case "dest":
+			spec.Dest = kv[1]
+		default:
+			return Spec{}, fmt.Errorf("unknown -output option %q", kv[0])
+		}
+	}
+
+	if spec.Dest == "" && spec.Type != TypeStdout {
+		return Spec{}, fmt.Errorf("-output type=%s requires dest=...", spec.Type)
+	}
+
+	return spec, nil
+}
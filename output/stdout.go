@@ -0,0 +1,18 @@
+package output
+
+import "os"
+
+func init() {
+	Register(TypeStdout, newStdoutSink)
+}
+
+type stdoutSink struct{}
+
+func newStdoutSink(dest string) (Sink, error) {
+	return stdoutSink{}, nil
+}
+
+func (stdoutSink) Write(res Result) error {
+	_, err := os.Stdout.Write(res.Raw)
+	return err
+}
@@ -0,0 +1,79 @@
+package output
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"os"
+	"time"
+)
+
+func init() {
+	Register(TypeTar, newTarSink)
+}
+
+type tarSink struct {
+	dest string
+}
+
+func newTarSink(dest string) (Sink, error) {
+	return tarSink{dest: dest}, nil
+}
+
+type tarManifest struct {
+	Count int      `json:"count"`
+	Ids   []string `json:"ids,omitempty"`
+}
+
+// Write emits a tar stream with the result JSON under "result.json" and a
+// small "manifest.json" describing it, so the archive can be piped into
+// other tools. dest=- writes the stream to stdout.
+func (s tarSink) Write(res Result) error {
+	manifest := tarManifest{Count: len(res.Records)}
+	for _, raw := range res.Records {
+		manifest.Ids = append(manifest.Ids, recordID(raw))
+	}
+
+	manifestJSON, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+
+	var w *os.File
+	if s.dest == "-" {
+		w = os.Stdout
+	} else {
+		w, err = os.OpenFile(s.dest, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0660)
+		if err != nil {
+			return err
+		}
+		defer w.Close()
+	}
+
+	tw := tar.NewWriter(w)
+
+	if err := writeTarEntry(tw, "result.json", res.Raw); err != nil {
+		return err
+	}
+
+	if err := writeTarEntry(tw, "manifest.json", manifestJSON); err != nil {
+		return err
+	}
+
+	return tw.Close()
+}
+
+func writeTarEntry(tw *tar.Writer, name string, data []byte) error {
+	hdr := &tar.Header{
+		Name:    name,
+		Mode:    0660,
+		Size:    int64(len(data)),
+		ModTime: time.Now(),
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	_, err := tw.Write(data)
+	return err
+}
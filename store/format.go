@@ -0,0 +1,55 @@
+package store
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"os"
+)
+
+// Format names the on-disk encoding of a DB's file.
+type Format string
+
+const (
+	// FormatNDJSON stores one JSON record per line, append-only, with
+	// {"id":"...","_deleted":true} tombstone lines marking removals.
+	FormatNDJSON Format = "ndjson"
+	// FormatJSONArray is the original format: the whole record set
+	// encoded as a single JSON array, rewritten on every mutation.
+	FormatJSONArray Format = "jsonarray"
+)
+
+// detectFormat peeks the first non-whitespace byte of path to tell a
+// legacy JSON array ('[') from newline-delimited JSON ('{'). A missing or
+// empty file defaults to def.
+func detectFormat(path string, def Format) (Format, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return def, nil
+	}
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	for {
+		b, err := r.ReadByte()
+		if err == io.EOF {
+			return def, nil
+		}
+		if err != nil {
+			return "", err
+		}
+
+		switch b {
+		case ' ', '\t', '\n', '\r':
+			continue
+		case '[':
+			return FormatJSONArray, nil
+		default:
+			return FormatNDJSON, nil
+		}
+	}
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package store
+
+import (
+	"os"
+	"syscall"
+)
+
+func lockFile(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	return syscall.LockFileEx(syscall.Handle(f.Fd()), syscall.LOCKFILE_EXCLUSIVE_LOCK, 0, 1, 0, ol)
+}
+
+func unlockFile(f *os.File) error {
+	ol := new(syscall.Overlapped)
+	return syscall.UnlockFileEx(syscall.Handle(f.Fd()), 0, 1, 0, ol)
+}
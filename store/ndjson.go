@@ -0,0 +1,469 @@
+package store
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+)
+
+// lineMeta is the id/tombstone bit every ndjson line carries; it's used
+// both to peek a scanned line's id/deleted state and to marshal a new
+// tombstone line.
+type lineMeta struct {
+	Id      string `json:"id"`
+	Deleted bool   `json:"_deleted"`
+}
+
+func newLineScanner(f *os.File) *bufio.Scanner {
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	return scanner
+}
+
+// loadNDJSON rebuilds the id index and line/tombstone counters by
+// scanning the log once; it never keeps the records themselves in memory.
+func (db *DB) loadNDJSON() error {
+	f, err := os.Open(db.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	ids := make(map[string]bool)
+	lineCount := 0
+	tombstones := 0
+
+	scanner := newLineScanner(f)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var meta lineMeta
+		if err := json.Unmarshal(line, &meta); err != nil {
+			return err
+		}
+
+		lineCount++
+		if meta.Deleted {
+			delete(ids, meta.Id)
+			tombstones++
+			continue
+		}
+		ids[meta.Id] = true
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	db.ids = ids
+	db.lineCount = lineCount
+	db.tombstones = tombstones
+
+	return nil
+}
+
+func (db *DB) addNDJSON(item string, w io.Writer) error {
+	raw := json.RawMessage(item)
+	if !json.Valid(raw) {
+		return errors.New("item is not valid JSON")
+	}
+
+	id := parseID(raw)
+	if id == "" {
+		return errors.New("item is missing an id")
+	}
+
+	result := make(chan mutationResult, 1)
+	db.saveChan <- mutation{kind: mutationAdd, id: id, record: raw, result: result}
+
+	out := <-result
+	if out.err != nil {
+		return out.err
+	}
+
+	_, err := w.Write(out.buf)
+	return err
+}
+
+func (db *DB) removeNDJSON(id string, w io.Writer) error {
+	result := make(chan mutationResult, 1)
+	db.saveChan <- mutation{kind: mutationRemove, id: id, result: result}
+
+	out := <-result
+	if out.err != nil {
+		return out.err
+	}
+
+	_, err := w.Write(out.buf)
+	return err
+}
+
+// applyNDJSONBatch appends one line per accepted add/remove straight onto
+// the log (no full-file rewrite), then compacts once tombstones pile up.
+func (db *DB) applyNDJSONBatch(batch []mutation) {
+	db.mu.Lock()
+
+	var appended []int
+	var lines bytes.Buffer
+
+	for i := range batch {
+		mut := &batch[i]
+
+		switch mut.kind {
+		case mutationAdd:
+			if db.ids[mut.id] {
+				mut.result <- mutationResult{buf: []byte("Item with id " + mut.id + " already exists")}
+				continue
+			}
+			db.ids[mut.id] = true
+			db.lineCount++
+			lines.Write(mut.record)
+			lines.WriteByte('\n')
+			appended = append(appended, i)
+		case mutationRemove:
+			if !db.ids[mut.id] {
+				mut.result <- mutationResult{buf: []byte("Item with id " + mut.id + " not found")}
+				continue
+			}
+			delete(db.ids, mut.id)
+			db.lineCount++
+			db.tombstones++
+			tomb, _ := json.Marshal(lineMeta{Id: mut.id, Deleted: true})
+			lines.Write(tomb)
+			lines.WriteByte('\n')
+			appended = append(appended, i)
+		}
+	}
+
+	needsCompaction := db.lineCount > 0 && float64(db.tombstones) > compactionTombstoneRatio*float64(db.lineCount)
+
+	var writeErr error
+	if len(appended) > 0 {
+		// Hold mu for the file write too, not just the in-memory index
+		// update: list/find read db.path under RLock, and an unlocked
+		// write here would let them observe a partially-written line.
+		writeErr = db.appendLines(lines.Bytes())
+	}
+
+	if writeErr == nil && needsCompaction {
+		if err := db.compactLocked(); err != nil {
+			fmt.Fprintln(os.Stderr, "ndjson compaction failed:", err)
+		}
+	}
+
+	db.mu.Unlock()
+
+	for _, i := range appended {
+		mut := &batch[i]
+		if writeErr != nil {
+			mut.result <- mutationResult{err: writeErr}
+			continue
+		}
+		switch mut.kind {
+		case mutationAdd:
+			mut.result <- mutationResult{buf: mut.record}
+		case mutationRemove:
+			mut.result <- mutationResult{buf: []byte("Item with id " + mut.id + " removed")}
+		}
+	}
+}
+
+func (db *DB) appendLines(data []byte) error {
+	f, err := os.OpenFile(db.path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// listNDJSON writes the log's live records to w as a JSON array. It never
+// unmarshals a record into a Go value or holds the file's full mutation
+// history (superseded adds, tombstones) in memory, but resolveLiveRecords
+// does buffer one raw JSON line per live record, so memory is
+// proportional to the live set, not constant.
+func (db *DB) listNDJSON(w io.Writer) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	f, err := os.Open(db.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	order, live, err := resolveLiveRecords(f)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write([]byte{'['}); err != nil {
+		return err
+	}
+
+	first := true
+	for _, id := range order {
+		raw, ok := live[id]
+		if !ok {
+			continue
+		}
+		if !first {
+			if _, err := w.Write([]byte{','}); err != nil {
+				return err
+			}
+		}
+		first = false
+		if _, err := w.Write(raw); err != nil {
+			return err
+		}
+	}
+
+	_, err = w.Write([]byte{']'})
+	return err
+}
+
+// findNDJSON streams the log line by line looking for id. A cheap
+// in-memory check first rules out ids that are not currently live; for a
+// live id we still have to read every one of its lines, since a removed
+// and later re-added id leaves an older, superseded add line earlier in
+// the log.
+func (db *DB) findNDJSON(id string, w io.Writer) error {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+
+	if !db.ids[id] {
+		return nil
+	}
+
+	f, err := os.Open(db.path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := newLineScanner(f)
+
+	var match []byte
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var meta lineMeta
+		if err := json.Unmarshal(line, &meta); err != nil {
+			return err
+		}
+		if meta.Id != id {
+			continue
+		}
+
+		if meta.Deleted {
+			match = nil
+			continue
+		}
+		match = append([]byte(nil), line...)
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	if match == nil {
+		return nil
+	}
+
+	_, err = w.Write(match)
+	return err
+}
+
+// resolveLiveRecords scans an ndjson log forward exactly once, resolving
+// each id to its current state: a later add or tombstone line always
+// supersedes an earlier one for the same id. It returns ids in the order
+// they first appeared (for stable list output) plus the live records,
+// keyed by id; memory is proportional to the live record count, not the
+// log's full mutation history.
+func resolveLiveRecords(f *os.File) ([]string, map[string]json.RawMessage, error) {
+	scanner := newLineScanner(f)
+
+	var order []string
+	seen := make(map[string]bool)
+	live := make(map[string]json.RawMessage)
+
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(bytes.TrimSpace(line)) == 0 {
+			continue
+		}
+
+		var meta lineMeta
+		if err := json.Unmarshal(line, &meta); err != nil {
+			return nil, nil, err
+		}
+
+		if !seen[meta.Id] {
+			seen[meta.Id] = true
+			order = append(order, meta.Id)
+		}
+
+		if meta.Deleted {
+			delete(live, meta.Id)
+			continue
+		}
+
+		cp := make(json.RawMessage, len(line))
+		copy(cp, line)
+		live[meta.Id] = cp
+	}
+
+	return order, live, scanner.Err()
+}
+
+// compactLocked rewrites the log to contain exactly one line per live
+// record, crash-safely, and resets the tombstone/line counters. Callers
+// must hold db.mu for writing.
+func (db *DB) compactLocked() error {
+	f, err := os.Open(db.path)
+	if err != nil {
+		return err
+	}
+
+	order, live, err := resolveLiveRecords(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	count := 0
+	for _, id := range order {
+		raw, ok := live[id]
+		if !ok {
+			continue
+		}
+		buf.Write(raw)
+		buf.WriteByte('\n')
+		count++
+	}
+
+	if err := db.atomicWriteFile(buf.Bytes()); err != nil {
+		return err
+	}
+
+	db.lineCount = count
+	db.tombstones = 0
+
+	return nil
+}
+
+// migrate converts the DB between FormatNDJSON and FormatJSONArray,
+// rewriting the file in place.
+func (db *DB) migrate(target Format, w io.Writer) error {
+	if target == "" {
+		return errors.New("-format is required for migrate")
+	}
+
+	if target == db.format {
+		fmt.Fprintf(w, "already in %s format\n", target)
+		return nil
+	}
+
+	switch target {
+	case FormatNDJSON:
+		return db.migrateToNDJSON(w)
+	case FormatJSONArray:
+		return db.migrateToJSONArray(w)
+	default:
+		return fmt.Errorf("unknown format %q", target)
+	}
+}
+
+func (db *DB) migrateToNDJSON(w io.Writer) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	var buf bytes.Buffer
+	ids := make(map[string]bool, len(db.records))
+
+	for _, raw := range db.records {
+		buf.Write(raw)
+		buf.WriteByte('\n')
+		if id := parseID(raw); id != "" {
+			ids[id] = true
+		}
+	}
+
+	if err := db.atomicWriteFile(buf.Bytes()); err != nil {
+		return err
+	}
+
+	db.format = FormatNDJSON
+	db.ids = ids
+	db.lineCount = len(db.records)
+	db.tombstones = 0
+	db.records = nil
+	db.byID = nil
+
+	fmt.Fprintf(w, "migrated %d records to ndjson\n", len(ids))
+	return nil
+}
+
+func (db *DB) migrateToJSONArray(w io.Writer) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+
+	f, err := os.Open(db.path)
+	if err != nil {
+		return err
+	}
+	order, live, err := resolveLiveRecords(f)
+	f.Close()
+	if err != nil {
+		return err
+	}
+
+	records := make([]json.RawMessage, 0, len(order))
+	byID := make(map[string]int, len(order))
+	for _, id := range order {
+		raw, ok := live[id]
+		if !ok {
+			continue
+		}
+		byID[id] = len(records)
+		records = append(records, raw)
+	}
+
+	marshalled, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	if err := db.atomicWriteFile(marshalled); err != nil {
+		return err
+	}
+
+	db.format = FormatJSONArray
+	db.records = records
+	db.byID = byID
+	db.ids = nil
+	db.lineCount = 0
+	db.tombstones = 0
+
+	fmt.Fprintf(w, "migrated %d records to jsonarray\n", len(records))
+	return nil
+}
@@ -0,0 +1,418 @@
+// Package store provides a long-lived, file-backed record store. A DB
+// holds an OS advisory lock on a path+".lock" sidecar for its lifetime
+// (not the data file itself, since crash-safe writes replace its inode
+// via rename) and serializes mutations through a buffered channel
+// drained by a single background goroutine, so it can be embedded in
+// something that handles many concurrent requests (like a server)
+// without corrupting the file.
+//
+// Two on-disk formats are supported. FormatJSONArray keeps the whole
+// record set in memory and rewrites it on every mutation, same as the
+// original implementation. FormatNDJSON is an append-only log of one
+// record per line plus tombstone lines for removals, which makes add an
+// O(1) append and keeps memory proportional to the live record count
+// rather than the file's full mutation history; see ndjson.go.
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Args mirrors the CLI's Arguments map for the subset of operations a DB
+// supports.
+type Args map[string]string
+
+// Operation names, matching the CLI's.
+const (
+	OpAdd     = "add"
+	OpList    = "list"
+	OpFind    = "findById"
+	OpRemove  = "remove"
+	OpMigrate = "migrate"
+)
+
+// compactionTombstoneRatio is the fraction of tombstone lines (of the
+// log's total lines) that triggers a compaction in ndjson mode.
+const compactionTombstoneRatio = 0.25
+
+type mutationKind int
+
+const (
+	mutationAdd mutationKind = iota
+	mutationRemove
+)
+
+type mutation struct {
+	kind   mutationKind
+	id     string
+	record json.RawMessage
+	result chan mutationResult
+}
+
+type mutationResult struct {
+	buf []byte
+	err error
+}
+
+// DB is a long-lived store backed by a single file.
+type DB struct {
+	path     string
+	lockFile *os.File // holds the advisory lock on path+".lock" for the DB's lifetime
+	format   Format
+
+	mu sync.RWMutex
+
+	// FormatJSONArray bookkeeping: the whole record set in memory.
+	records []json.RawMessage
+	byID    map[string]int
+
+	// FormatNDJSON bookkeeping: only an id index and line counters, see
+	// ndjson.go.
+	ids        map[string]bool
+	lineCount  int
+	tombstones int
+
+	saveChan chan mutation
+	closed   chan struct{}
+}
+
+// NewDB opens path, detecting its on-disk Format, and takes an OS
+// advisory lock that is held until Close. format forces the format to use
+// for a brand-new/empty file; pass "" to default to FormatNDJSON.
+//
+// The lock is taken on a path+".lock" sidecar rather than path itself:
+// every crash-safe write (atomicWriteFile, ndjson compaction) replaces
+// path's inode via rename, and flock follows the fd's inode, so locking
+// path directly would stop protecting the file after its first rewrite.
+// The sidecar is created once and never renamed, so the lock stays valid
+// for the DB's whole lifetime.
+func NewDB(path string, format Format) (*DB, error) {
+	lockHandle, err := os.OpenFile(path+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := lockFile(lockHandle); err != nil {
+		lockHandle.Close()
+		return nil, fmt.Errorf("lock %s: %w", path, err)
+	}
+
+	dataFile, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		unlockFile(lockHandle)
+		lockHandle.Close()
+		return nil, err
+	}
+	dataFile.Close()
+
+	def := FormatNDJSON
+	if format != "" {
+		def = format
+	}
+
+	detected, err := detectFormat(path, def)
+	if err != nil {
+		unlockFile(lockHandle)
+		lockHandle.Close()
+		return nil, err
+	}
+
+	db := &DB{
+		path:     path,
+		lockFile: lockHandle,
+		format:   detected,
+		saveChan: make(chan mutation, 64),
+		closed:   make(chan struct{}),
+	}
+
+	var loadErr error
+	if detected == FormatJSONArray {
+		loadErr = db.loadArray()
+	} else {
+		loadErr = db.loadNDJSON()
+	}
+
+	if loadErr != nil {
+		unlockFile(lockHandle)
+		lockHandle.Close()
+		return nil, loadErr
+	}
+
+	go db.run()
+
+	return db, nil
+}
+
+// Perform runs a single add/list/findById/remove/migrate operation
+// against the DB and writes its result to w.
+func (db *DB) Perform(args Args, w io.Writer) error {
+	switch args["operation"] {
+	case OpList:
+		return db.list(w)
+	case OpAdd:
+		return db.add(args["item"], w)
+	case OpFind:
+		return db.find(args["id"], w)
+	case OpRemove:
+		return db.remove(args["id"], w)
+	case OpMigrate:
+		return db.migrate(Format(args["format"]), w)
+	default:
+		return fmt.Errorf("operation %q not allowed", args["operation"])
+	}
+}
+
+// Close stops the background writer goroutine (flushing anything queued)
+// and releases the sidecar lock.
+func (db *DB) Close() error {
+	close(db.saveChan)
+	<-db.closed
+
+	if err := unlockFile(db.lockFile); err != nil {
+		db.lockFile.Close()
+		return err
+	}
+
+	return db.lockFile.Close()
+}
+
+func (db *DB) run() {
+	defer close(db.closed)
+
+	for m := range db.saveChan {
+		batch := []mutation{m}
+
+	drain:
+		for {
+			select {
+			case next := <-db.saveChan:
+				batch = append(batch, next)
+			default:
+				break drain
+			}
+		}
+
+		if db.format == FormatJSONArray {
+			db.applyArrayBatch(batch)
+		} else {
+			db.applyNDJSONBatch(batch)
+		}
+	}
+}
+
+func (db *DB) list(w io.Writer) error {
+	if db.format == FormatJSONArray {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+
+		buf, err := json.Marshal(db.records)
+		if err != nil {
+			return err
+		}
+
+		_, err = w.Write(buf)
+		return err
+	}
+
+	return db.listNDJSON(w)
+}
+
+func (db *DB) find(id string, w io.Writer) error {
+	if db.format == FormatJSONArray {
+		db.mu.RLock()
+		defer db.mu.RUnlock()
+
+		idx, exists := db.byID[id]
+		if !exists {
+			return nil
+		}
+
+		_, err := w.Write(db.records[idx])
+		return err
+	}
+
+	return db.findNDJSON(id, w)
+}
+
+func (db *DB) add(item string, w io.Writer) error {
+	if db.format != FormatJSONArray {
+		return db.addNDJSON(item, w)
+	}
+
+	raw := json.RawMessage(item)
+	if !json.Valid(raw) {
+		return fmt.Errorf("item is not valid JSON")
+	}
+
+	id := parseID(raw)
+	if id == "" {
+		return fmt.Errorf("item is missing an id")
+	}
+
+	result := make(chan mutationResult, 1)
+	db.saveChan <- mutation{kind: mutationAdd, id: id, record: raw, result: result}
+
+	out := <-result
+	if out.err != nil {
+		return out.err
+	}
+
+	_, err := w.Write(out.buf)
+	return err
+}
+
+func (db *DB) remove(id string, w io.Writer) error {
+	if db.format != FormatJSONArray {
+		return db.removeNDJSON(id, w)
+	}
+
+	result := make(chan mutationResult, 1)
+	db.saveChan <- mutation{kind: mutationRemove, id: id, result: result}
+
+	out := <-result
+	if out.err != nil {
+		return out.err
+	}
+
+	_, err := w.Write(out.buf)
+	return err
+}
+
+func (db *DB) loadArray() error {
+	buf, err := os.ReadFile(db.path)
+	if err != nil {
+		return err
+	}
+
+	records := []json.RawMessage{}
+	if len(buf) != 0 {
+		if err := json.Unmarshal(buf, &records); err != nil {
+			return err
+		}
+	}
+
+	db.records = records
+	db.byID = indexByID(records)
+
+	return nil
+}
+
+// applyArrayBatch mutates the in-memory record set and rewrites the whole
+// file, same as the original per-call open/read/write implementation.
+func (db *DB) applyArrayBatch(batch []mutation) {
+	db.mu.Lock()
+
+	var pending []int
+	for i := range batch {
+		mut := &batch[i]
+
+		switch mut.kind {
+		case mutationAdd:
+			if _, exists := db.byID[mut.id]; exists {
+				mut.result <- mutationResult{buf: []byte("Item with id " + mut.id + " already exists")}
+				continue
+			}
+			db.byID[mut.id] = len(db.records)
+			db.records = append(db.records, mut.record)
+			pending = append(pending, i)
+		case mutationRemove:
+			idx, exists := db.byID[mut.id]
+			if !exists {
+				mut.result <- mutationResult{buf: []byte("Item with id " + mut.id + " not found")}
+				continue
+			}
+			db.records = append(db.records[:idx], db.records[idx+1:]...)
+			db.byID = indexByID(db.records)
+			pending = append(pending, i)
+		}
+	}
+
+	var snapshot []json.RawMessage
+	if len(pending) > 0 {
+		snapshot = append([]json.RawMessage(nil), db.records...)
+	}
+
+	db.mu.Unlock()
+
+	if len(pending) == 0 {
+		return
+	}
+
+	marshalled, writeErr := json.Marshal(snapshot)
+	if writeErr == nil {
+		writeErr = db.atomicWriteFile(marshalled)
+	}
+
+	for _, i := range pending {
+		mut := &batch[i]
+		if writeErr != nil {
+			mut.result <- mutationResult{err: writeErr}
+			continue
+		}
+		// Report per-mutation, not the whole rewritten array: this
+		// matches the shape the ndjson path reports (see
+		// applyNDJSONBatch), so a given CLI command prints the same
+		// thing regardless of the DB's on-disk format.
+		switch mut.kind {
+		case mutationAdd:
+			mut.result <- mutationResult{buf: mut.record}
+		case mutationRemove:
+			mut.result <- mutationResult{buf: []byte("Item with id " + mut.id + " removed")}
+		}
+	}
+}
+
+// atomicWriteFile writes data to db.path crash-safely: write to a temp
+// file, fsync, then rename over the real path.
+func (db *DB) atomicWriteFile(data []byte) error {
+	tmp := db.path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, db.path)
+}
+
+func indexByID(records []json.RawMessage) map[string]int {
+	idx := make(map[string]int, len(records))
+
+	for i, raw := range records {
+		if id := parseID(raw); id != "" {
+			idx[id] = i
+		}
+	}
+
+	return idx
+}
+
+func parseID(raw json.RawMessage) string {
+	var rec struct {
+		Id string `json:"id"`
+	}
+
+	if err := json.Unmarshal(raw, &rec); err != nil {
+		return ""
+	}
+
+	return rec.Id
+}
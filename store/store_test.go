@@ -0,0 +1,168 @@
+package store
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestDBConcurrentAddRemove(t *testing.T) {
+	for _, format := range []Format{FormatJSONArray, FormatNDJSON} {
+		t.Run(string(format), func(t *testing.T) {
+			testDBConcurrentAddRemove(t, format)
+		})
+	}
+}
+
+// testDBConcurrentAddRemove fires n goroutines at Perform concurrently -
+// every odd id is added then immediately removed again - and checks the
+// survivors through Perform(OpList), so it exercises whichever format's
+// mutation/compaction path is under test the same way a real concurrent
+// embedder would.
+func testDBConcurrentAddRemove(t *testing.T, format Format) {
+	ext := "json"
+	if format == FormatNDJSON {
+		ext = "ndjson"
+	}
+	path := filepath.Join(t.TempDir(), "db."+ext)
+
+	db, err := NewDB(path, format)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+
+	const n = 50
+
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			id := fmt.Sprintf("r%d", i)
+			item := fmt.Sprintf(`{"id":%q,"email":"e@x.com","age":1}`, id)
+
+			var addBuf bytes.Buffer
+			if err := db.Perform(Args{"operation": OpAdd, "item": item}, &addBuf); err != nil {
+				t.Errorf("add %s: %v", id, err)
+				return
+			}
+
+			// interleave: odd ids are added then immediately removed again
+			if i%2 == 1 {
+				var removeBuf bytes.Buffer
+				if err := db.Perform(Args{"operation": OpRemove, "id": id}, &removeBuf); err != nil {
+					t.Errorf("remove %s: %v", id, err)
+				}
+			}
+		}(i)
+	}
+
+	wg.Wait()
+
+	var listBuf bytes.Buffer
+	if err := db.Perform(Args{"operation": OpList}, &listBuf); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	var records []struct {
+		Id string `json:"id"`
+	}
+
+	if err := json.Unmarshal(listBuf.Bytes(), &records); err != nil {
+		t.Fatalf("list did not return a JSON array: %v", err)
+	}
+
+	if want := n / 2; len(records) != want {
+		t.Fatalf("got %d records, want %d", len(records), want)
+	}
+
+	seen := make(map[string]bool, len(records))
+	for _, rec := range records {
+		seen[rec.Id] = true
+	}
+
+	for i := 0; i < n; i += 2 {
+		id := fmt.Sprintf("r%d", i)
+		if !seen[id] {
+			t.Errorf("missing expected id %s", id)
+		}
+	}
+}
+
+func TestDBNDJSONCompactionAndMigrate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.ndjson")
+
+	db, err := NewDB(path, FormatNDJSON)
+	if err != nil {
+		t.Fatalf("NewDB: %v", err)
+	}
+
+	const n = 12
+
+	for i := 0; i < n; i++ {
+		id := fmt.Sprintf("r%d", i)
+		item := fmt.Sprintf(`{"id":%q,"email":"e@x.com","age":1}`, id)
+
+		var buf bytes.Buffer
+		if err := db.Perform(Args{"operation": OpAdd, "item": item}, &buf); err != nil {
+			t.Fatalf("add %s: %v", id, err)
+		}
+
+		// remove most of them, to push well past the 25% tombstone
+		// threshold and exercise compaction.
+		if i < n-2 {
+			var removeBuf bytes.Buffer
+			if err := db.Perform(Args{"operation": OpRemove, "id": id}, &removeBuf); err != nil {
+				t.Fatalf("remove %s: %v", id, err)
+			}
+		}
+	}
+
+	var listBuf bytes.Buffer
+	if err := db.Perform(Args{"operation": OpList}, &listBuf); err != nil {
+		t.Fatalf("list: %v", err)
+	}
+
+	var records []struct {
+		Id string `json:"id"`
+	}
+	if err := json.Unmarshal(listBuf.Bytes(), &records); err != nil {
+		t.Fatalf("list did not return a JSON array: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("got %d live records, want 2", len(records))
+	}
+
+	var migrateBuf bytes.Buffer
+	if err := db.Perform(Args{"operation": OpMigrate, "format": string(FormatJSONArray)}, &migrateBuf); err != nil {
+		t.Fatalf("migrate: %v", err)
+	}
+
+	if err := db.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("ReadFile: %v", err)
+	}
+
+	var migrated []struct {
+		Id string `json:"id"`
+	}
+	if err := json.Unmarshal(raw, &migrated); err != nil {
+		t.Fatalf("migrated file is not a JSON array: %v", err)
+	}
+	if len(migrated) != 2 {
+		t.Fatalf("got %d records after migrate, want 2", len(migrated))
+	}
+}